@@ -0,0 +1,127 @@
+package info
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"../libts"
+)
+
+// Main implements the "info" subcommand: it prints a tree of
+// network/service/event metadata decoded from a TS file's NIT, SDT and EIT.
+func Main(args []string) {
+	commandLine := flag.NewFlagSet("info", flag.ExitOnError)
+	commandLine.Parse(args)
+	args = commandLine.Args()
+	if len(args) > 1 {
+		log.Fatal("Invalid number of arguments")
+	}
+	inf := os.Stdin
+	if len(args) >= 1 && args[0] != "-" {
+		var e error
+		inf, e = os.Open(args[0])
+		if e != nil {
+			log.Fatal(e)
+		}
+		defer inf.Close()
+	}
+	in := bufio.NewReader(inf)
+
+	networks := make(map[uint16]*libts.NITSection)
+	services := make(map[uint16]*libts.Service)
+	events := make(map[uint16][]*libts.Event)
+	// seenEvents tracks which (service_id, event_id) pairs have already
+	// been recorded, since present/following and schedule EIT sections
+	// are retransmitted continuously and would otherwise be appended
+	// (and printed) once per retransmission.
+	seenEvents := make(map[uint16]map[uint16]bool)
+
+	nitd := libts.NewNITSectionDecoder(func(sec *libts.NITSection) {
+		networks[sec.NetworkID] = sec
+	})
+	sdtd := libts.NewSDTSectionDecoder(func(sec *libts.SDTSection) {
+		for i := range sec.Services {
+			svc := sec.Services[i]
+			services[svc.ServiceID] = &svc
+		}
+	})
+	eitd := libts.NewEITSectionDecoder(func(sec *libts.EITSection) {
+		seen, ok := seenEvents[sec.ServiceID]
+		if !ok {
+			seen = make(map[uint16]bool)
+			seenEvents[sec.ServiceID] = seen
+		}
+		for i := range sec.Events {
+			event := sec.Events[i]
+			if seen[event.EventID] {
+				continue
+			}
+			seen[event.EventID] = true
+			events[sec.ServiceID] = append(events[sec.ServiceID], &event)
+		}
+	})
+
+	pr := libts.NewPacketReader(in)
+	for {
+		packet, e := pr.ReadPacket()
+		if e != nil {
+			break
+		}
+		switch packet.PID {
+		case libts.NIT_PID:
+			nitd.Submit(packet)
+		case libts.SDT_PID:
+			sdtd.Submit(packet)
+		case libts.EIT_PID:
+			eitd.Submit(packet)
+		}
+	}
+	if pr.Stats.ResyncCount > 0 {
+		log.Printf("stream quality: resynchronized %d time(s), dropped %d byte(s)",
+			pr.Stats.ResyncCount, pr.Stats.DroppedBytes)
+	}
+
+	printTree(networks, services, events)
+}
+
+func printTree(
+	networks map[uint16]*libts.NITSection,
+	services map[uint16]*libts.Service,
+	events map[uint16][]*libts.Event) {
+
+	decoder := libts.DefaultTextDecoder
+
+	network_ids := make([]int, 0, len(networks))
+	for id := range networks {
+		network_ids = append(network_ids, int(id))
+	}
+	sort.Ints(network_ids)
+	for _, id := range network_ids {
+		network := networks[uint16(id)]
+		name, _ := network.NetworkName(decoder)
+		fmt.Printf("network %d: %s\n", network.NetworkID, name)
+	}
+
+	service_ids := make([]int, 0, len(services))
+	for id := range services {
+		service_ids = append(service_ids, int(id))
+	}
+	sort.Ints(service_ids)
+	for _, id := range service_ids {
+		service := services[uint16(id)]
+		provider, name, _ := service.ServiceName(decoder)
+		fmt.Printf("  service %d: %s (%s)\n", service.ServiceID, name, provider)
+		for _, event := range events[service.ServiceID] {
+			name, description, ok := event.ShortEvent(decoder)
+			if !ok {
+				name = fmt.Sprintf("event %d", event.EventID)
+			}
+			fmt.Printf("    %s - %s: %s\n",
+				event.StartTime.Format("2006-01-02 15:04"), name, description)
+		}
+	}
+}
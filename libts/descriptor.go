@@ -0,0 +1,475 @@
+package libts
+
+import "fmt"
+
+// descriptor_tag values used by ParseDescriptors, from ISO 13818-1 Table
+// 2-39 and the DVB/ARIB extensions (ETSI EN 300 468) commonly found
+// alongside it in Japanese TS.
+const (
+	DescriptorTag_NetworkName      = 0x40
+	DescriptorTag_Registration     = 0x05
+	DescriptorTag_CA               = 0x09
+	DescriptorTag_ISO639Language   = 0x0a
+	DescriptorTag_ShortEvent       = 0x4d
+	DescriptorTag_Service          = 0x48
+	DescriptorTag_Component        = 0x50
+	DescriptorTag_StreamIdentifier = 0x52
+	DescriptorTag_AC3              = 0x6a
+)
+
+// UnknownDescriptor is used for any descriptor_tag ParseDescriptors does
+// not know how to decode; its raw bytes are kept so callers can still
+// inspect or re-serialize it.
+type UnknownDescriptor struct {
+	Tag  uint8
+	Data []byte
+}
+
+func (d *UnknownDescriptor) Print() {
+	fmt.Printf("unknown descriptor, tag: %d, length: %d\n", d.Tag, len(d.Data))
+}
+
+func (d *UnknownDescriptor) Bytes() []byte {
+	return descriptorBytes(d.Tag, d.Data)
+}
+
+// RegistrationDescriptor identifies the private data format of the stream
+// it is attached to by a 4 byte format_identifier, e.g. "AC-3" for an
+// ATSC/ARIB AC-3 elementary stream signalled outside of stream_type.
+// ISO 13818-1 2.6.8.
+type RegistrationDescriptor struct {
+	FormatIdentifier uint32
+	AdditionalInfo   []byte
+}
+
+func (d *RegistrationDescriptor) Print() {
+	fmt.Printf("registration descriptor, format identifier: %08x\n", d.FormatIdentifier)
+}
+
+func (d *RegistrationDescriptor) Bytes() []byte {
+	body := make([]byte, 4+len(d.AdditionalInfo))
+	body[0] = byte(d.FormatIdentifier >> 24)
+	body[1] = byte(d.FormatIdentifier >> 16)
+	body[2] = byte(d.FormatIdentifier >> 8)
+	body[3] = byte(d.FormatIdentifier)
+	copy(body[4:], d.AdditionalInfo)
+	return descriptorBytes(DescriptorTag_Registration, body)
+}
+
+// CADescriptor signals the PID carrying ECMs/EMMs for a scrambled
+// program or stream. ISO 13818-1 2.6.16.
+type CADescriptor struct {
+	CASystemID  uint16
+	CA_PID      uint16
+	PrivateData []byte
+}
+
+func (d *CADescriptor) Print() {
+	fmt.Printf("CA descriptor, CA system id: %d, CA pid: %d\n", d.CASystemID, d.CA_PID)
+}
+
+func (d *CADescriptor) Bytes() []byte {
+	body := make([]byte, 4+len(d.PrivateData))
+	body[0] = byte(d.CASystemID >> 8)
+	body[1] = byte(d.CASystemID)
+	body[2] = byte(d.CA_PID>>8)&0x1f | 0xe0
+	body[3] = byte(d.CA_PID)
+	copy(body[4:], d.PrivateData)
+	return descriptorBytes(DescriptorTag_CA, body)
+}
+
+// ISO639Language is a single entry of ISO639LanguageDescriptor.
+type ISO639Language struct {
+	LanguageCode [3]byte
+	AudioType    uint8
+}
+
+// ISO639LanguageDescriptor carries the language, and for audio streams the
+// audio_type (e.g. clean effects, hearing impaired), of the stream it is
+// attached to. ISO 13818-1 2.6.18.
+type ISO639LanguageDescriptor struct {
+	Languages []ISO639Language
+}
+
+func (d *ISO639LanguageDescriptor) Print() {
+	fmt.Printf("ISO 639 language descriptor:\n")
+	for _, l := range d.Languages {
+		fmt.Printf("  language: %s, audio type: %d\n", string(l.LanguageCode[:]), l.AudioType)
+	}
+}
+
+func (d *ISO639LanguageDescriptor) Bytes() []byte {
+	body := make([]byte, len(d.Languages)*4)
+	for i, l := range d.Languages {
+		p := i * 4
+		copy(body[p:p+3], l.LanguageCode[:])
+		body[p+3] = l.AudioType
+	}
+	return descriptorBytes(DescriptorTag_ISO639Language, body)
+}
+
+// StreamIdentifierDescriptor tags an elementary stream with a
+// component_tag, letting other tables (e.g. component_descriptor in an
+// EIT) refer back to it. ETSI EN 300 468 6.2.39.
+type StreamIdentifierDescriptor struct {
+	ComponentTag uint8
+}
+
+func (d *StreamIdentifierDescriptor) Print() {
+	fmt.Printf("stream identifier descriptor, component tag: %d\n", d.ComponentTag)
+}
+
+func (d *StreamIdentifierDescriptor) Bytes() []byte {
+	return descriptorBytes(DescriptorTag_StreamIdentifier, []byte{d.ComponentTag})
+}
+
+// ServiceDescriptor carries the provider and service name of a program,
+// as found in an SDT but also legal wherever program_info is. ETSI EN 300
+// 468 6.2.33. The name fields are the raw text bytes: decoding ARIB/DVB
+// text encodings into UTF-8 is the job of a TextDecoder.
+type ServiceDescriptor struct {
+	ServiceType         uint8
+	ServiceProviderName []byte
+	ServiceName         []byte
+}
+
+func (d *ServiceDescriptor) Print() {
+	fmt.Printf("service descriptor, type: %d, provider: %q, name: %q\n",
+		d.ServiceType, d.ServiceProviderName, d.ServiceName)
+}
+
+func (d *ServiceDescriptor) Bytes() []byte {
+	body := make([]byte, 0, 2+len(d.ServiceProviderName)+1+len(d.ServiceName))
+	body = append(body, d.ServiceType, byte(len(d.ServiceProviderName)))
+	body = append(body, d.ServiceProviderName...)
+	body = append(body, byte(len(d.ServiceName)))
+	body = append(body, d.ServiceName...)
+	return descriptorBytes(DescriptorTag_Service, body)
+}
+
+// ComponentDescriptor describes a single component (e.g. video angle,
+// audio track) of a service for display to the user, as commonly used by
+// ARIB/DVB receivers to label multi-audio/multi-view streams. ETSI EN 300
+// 468 6.2.8.
+type ComponentDescriptor struct {
+	StreamContent      uint8
+	ComponentType      uint8
+	ComponentTag       uint8
+	ISO639LanguageCode [3]byte
+	Text               []byte
+}
+
+func (d *ComponentDescriptor) Print() {
+	fmt.Printf("component descriptor, stream content: %d, component type: %d, tag: %d, language: %s, text: %q\n",
+		d.StreamContent, d.ComponentType, d.ComponentTag,
+		string(d.ISO639LanguageCode[:]), d.Text)
+}
+
+func (d *ComponentDescriptor) Bytes() []byte {
+	body := make([]byte, 6+len(d.Text))
+	body[0] = d.StreamContent&0x0f | 0xf0
+	body[1] = d.ComponentType
+	body[2] = d.ComponentTag
+	copy(body[3:6], d.ISO639LanguageCode[:])
+	copy(body[6:], d.Text)
+	return descriptorBytes(DescriptorTag_Component, body)
+}
+
+// AC3Descriptor signals an AC-3/E-AC-3 audio elementary stream and its
+// optional component_type/bsid/mainid/asvc, used since DVB stream_type
+// does not have a dedicated AC-3 value. ETSI EN 300 468 Annex D.
+type AC3Descriptor struct {
+	ComponentTypeFlag bool
+	BSIDFlag          bool
+	MainIDFlag        bool
+	ASVCFlag          bool
+	ComponentType     uint8
+	BSID              uint8
+	MainID            uint8
+	ASVC              uint8
+	AdditionalInfo    []byte
+}
+
+func (d *AC3Descriptor) Print() {
+	fmt.Printf("AC-3 descriptor, component type: %d, bsid: %d, main id: %d, asvc: %d\n",
+		d.ComponentType, d.BSID, d.MainID, d.ASVC)
+}
+
+func (d *AC3Descriptor) Bytes() []byte {
+	flags := byte(0)
+	if d.ComponentTypeFlag {
+		flags |= 0x80
+	}
+	if d.BSIDFlag {
+		flags |= 0x40
+	}
+	if d.MainIDFlag {
+		flags |= 0x20
+	}
+	if d.ASVCFlag {
+		flags |= 0x10
+	}
+	body := []byte{flags}
+	if d.ComponentTypeFlag {
+		body = append(body, d.ComponentType)
+	}
+	if d.BSIDFlag {
+		body = append(body, d.BSID)
+	}
+	if d.MainIDFlag {
+		body = append(body, d.MainID)
+	}
+	if d.ASVCFlag {
+		body = append(body, d.ASVC)
+	}
+	body = append(body, d.AdditionalInfo...)
+	return descriptorBytes(DescriptorTag_AC3, body)
+}
+
+// NetworkNameDescriptor carries the raw text bytes of a network's name, as
+// found in a NIT's network_descriptors. ETSI EN 300 468 6.2.27.
+type NetworkNameDescriptor struct {
+	NetworkName []byte
+}
+
+func (d *NetworkNameDescriptor) Print() {
+	fmt.Printf("network name descriptor, name: %q\n", d.NetworkName)
+}
+
+func (d *NetworkNameDescriptor) Bytes() []byte {
+	return descriptorBytes(DescriptorTag_NetworkName, d.NetworkName)
+}
+
+// ShortEventDescriptor carries an event's name and a short description, as
+// found in an EIT. ETSI EN 300 468 6.2.37.
+type ShortEventDescriptor struct {
+	ISO639LanguageCode [3]byte
+	EventName          []byte
+	Text               []byte
+}
+
+func (d *ShortEventDescriptor) Print() {
+	fmt.Printf("short event descriptor, language: %s, name: %q, text: %q\n",
+		string(d.ISO639LanguageCode[:]), d.EventName, d.Text)
+}
+
+func (d *ShortEventDescriptor) Bytes() []byte {
+	body := make([]byte, 0, 3+1+len(d.EventName)+1+len(d.Text))
+	body = append(body, d.ISO639LanguageCode[:]...)
+	body = append(body, byte(len(d.EventName)))
+	body = append(body, d.EventName...)
+	body = append(body, byte(len(d.Text)))
+	body = append(body, d.Text...)
+	return descriptorBytes(DescriptorTag_ShortEvent, body)
+}
+
+func parseNetworkNameDescriptor(data []byte) (Descriptor, error) {
+	return &NetworkNameDescriptor{NetworkName: data}, nil
+}
+
+func parseShortEventDescriptor(data []byte) (Descriptor, error) {
+	if len(data) < 5 {
+		return nil, fmt.Errorf("short event descriptor is too short: %d bytes", len(data))
+	}
+	d := &ShortEventDescriptor{}
+	copy(d.ISO639LanguageCode[:], data[0:3])
+	event_name_length := int(data[3])
+	p := 4
+	if len(data) < p+event_name_length+1 {
+		return nil, fmt.Errorf("short event descriptor is too short for event name")
+	}
+	d.EventName = data[p : p+event_name_length]
+	p += event_name_length
+	text_length := int(data[p])
+	p++
+	if len(data) < p+text_length {
+		return nil, fmt.Errorf("short event descriptor is too short for text")
+	}
+	d.Text = data[p : p+text_length]
+	return d, nil
+}
+
+func parseRegistrationDescriptor(data []byte) (Descriptor, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("registration descriptor is too short: %d bytes", len(data))
+	}
+	return &RegistrationDescriptor{
+		FormatIdentifier: uint32(data[0])<<24 | uint32(data[1])<<16 |
+			uint32(data[2])<<8 | uint32(data[3]),
+		AdditionalInfo: data[4:],
+	}, nil
+}
+
+func parseCADescriptor(data []byte) (Descriptor, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("CA descriptor is too short: %d bytes", len(data))
+	}
+	return &CADescriptor{
+		CASystemID:  uint16(data[0])<<8 | uint16(data[1]),
+		CA_PID:      ReadPID(data[2:]),
+		PrivateData: data[4:],
+	}, nil
+}
+
+func parseISO639LanguageDescriptor(data []byte) (Descriptor, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("ISO 639 language descriptor has a non-multiple-of-4 length: %d", len(data))
+	}
+	d := &ISO639LanguageDescriptor{Languages: make([]ISO639Language, len(data)/4)}
+	for i := range d.Languages {
+		p := i * 4
+		copy(d.Languages[i].LanguageCode[:], data[p:p+3])
+		d.Languages[i].AudioType = data[p+3]
+	}
+	return d, nil
+}
+
+func parseStreamIdentifierDescriptor(data []byte) (Descriptor, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("stream identifier descriptor is too short: %d bytes", len(data))
+	}
+	return &StreamIdentifierDescriptor{ComponentTag: data[0]}, nil
+}
+
+func parseServiceDescriptor(data []byte) (Descriptor, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("service descriptor is too short: %d bytes", len(data))
+	}
+	d := &ServiceDescriptor{ServiceType: data[0]}
+	provider_name_length := int(data[1])
+	p := 2
+	if len(data) < p+provider_name_length+1 {
+		return nil, fmt.Errorf("service descriptor is too short for provider name")
+	}
+	d.ServiceProviderName = data[p : p+provider_name_length]
+	p += provider_name_length
+	service_name_length := int(data[p])
+	p++
+	if len(data) < p+service_name_length {
+		return nil, fmt.Errorf("service descriptor is too short for service name")
+	}
+	d.ServiceName = data[p : p+service_name_length]
+	return d, nil
+}
+
+func parseComponentDescriptor(data []byte) (Descriptor, error) {
+	if len(data) < 6 {
+		return nil, fmt.Errorf("component descriptor is too short: %d bytes", len(data))
+	}
+	d := &ComponentDescriptor{
+		StreamContent: data[0] & 0x0f,
+		ComponentType: data[1],
+		ComponentTag:  data[2],
+	}
+	copy(d.ISO639LanguageCode[:], data[3:6])
+	d.Text = data[6:]
+	return d, nil
+}
+
+func parseAC3Descriptor(data []byte) (Descriptor, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("AC-3 descriptor is too short: %d bytes", len(data))
+	}
+	d := &AC3Descriptor{
+		ComponentTypeFlag: data[0]&0x80 != 0,
+		BSIDFlag:          data[0]&0x40 != 0,
+		MainIDFlag:        data[0]&0x20 != 0,
+		ASVCFlag:          data[0]&0x10 != 0,
+	}
+	p := 1
+	if d.ComponentTypeFlag {
+		if len(data) < p+1 {
+			return nil, fmt.Errorf("AC-3 descriptor is too short for component_type")
+		}
+		d.ComponentType = data[p]
+		p++
+	}
+	if d.BSIDFlag {
+		if len(data) < p+1 {
+			return nil, fmt.Errorf("AC-3 descriptor is too short for bsid")
+		}
+		d.BSID = data[p]
+		p++
+	}
+	if d.MainIDFlag {
+		if len(data) < p+1 {
+			return nil, fmt.Errorf("AC-3 descriptor is too short for mainid")
+		}
+		d.MainID = data[p]
+		p++
+	}
+	if d.ASVCFlag {
+		if len(data) < p+1 {
+			return nil, fmt.Errorf("AC-3 descriptor is too short for asvc")
+		}
+		d.ASVC = data[p]
+		p++
+	}
+	d.AdditionalInfo = data[p:]
+	return d, nil
+}
+
+// descriptorParsers maps descriptor_tag to its decoder. Unrecognized tags
+// fall back to UnknownDescriptor in ParseDescriptors.
+var descriptorParsers = map[uint8]func([]byte) (Descriptor, error){
+	DescriptorTag_NetworkName:      parseNetworkNameDescriptor,
+	DescriptorTag_Registration:     parseRegistrationDescriptor,
+	DescriptorTag_CA:               parseCADescriptor,
+	DescriptorTag_ISO639Language:   parseISO639LanguageDescriptor,
+	DescriptorTag_ShortEvent:       parseShortEventDescriptor,
+	DescriptorTag_Service:          parseServiceDescriptor,
+	DescriptorTag_Component:        parseComponentDescriptor,
+	DescriptorTag_StreamIdentifier: parseStreamIdentifierDescriptor,
+	DescriptorTag_AC3:              parseAC3Descriptor,
+}
+
+// ParseDescriptors decodes a descriptor loop (a sequence of
+// descriptor_tag/descriptor_length/data) such as PMT's program_info or a
+// StreamInfo's ES_info. Descriptors with an unknown tag, or whose body
+// fails to parse, are kept as an UnknownDescriptor rather than dropped.
+func ParseDescriptors(data []byte) []Descriptor {
+	descriptors := make([]Descriptor, 0)
+	for len(data) >= 2 {
+		tag := data[0]
+		length := int(data[1])
+		if len(data) < 2+length {
+			break
+		}
+		body := data[2 : 2+length]
+		descriptor, e := parseDescriptor(tag, body)
+		if e != nil {
+			descriptor = &UnknownDescriptor{Tag: tag, Data: body}
+		}
+		descriptors = append(descriptors, descriptor)
+		data = data[2+length:]
+	}
+	return descriptors
+}
+
+func parseDescriptor(tag uint8, body []byte) (Descriptor, error) {
+	parse, ok := descriptorParsers[tag]
+	if !ok {
+		return &UnknownDescriptor{Tag: tag, Data: body}, nil
+	}
+	return parse(body)
+}
+
+// descriptorBytes prefixes body with its descriptor_tag/descriptor_length
+// header, as shared by every Descriptor's Bytes() implementation.
+func descriptorBytes(tag uint8, body []byte) []byte {
+	out := make([]byte, 2+len(body))
+	out[0] = tag
+	out[1] = byte(len(body))
+	copy(out[2:], body)
+	return out
+}
+
+// BuildDescriptors concatenates the Bytes() of descriptors back into a
+// single descriptor loop, the inverse of ParseDescriptors.
+func BuildDescriptors(descriptors []Descriptor) []byte {
+	out := make([]byte, 0)
+	for _, d := range descriptors {
+		out = append(out, d.Bytes()...)
+	}
+	return out
+}
@@ -0,0 +1,267 @@
+package libts
+
+import (
+	"fmt"
+	"io"
+)
+
+// DefaultPCRInterval is the default spacing between PCR samples a
+// PacketWriter inserts on its PCR PID, matching common muxers' 40ms.
+const DefaultPCRInterval = 40
+
+// PacketWriter serializes TSPackets, handling continuity-counter
+// bookkeeping per PID and padding short payloads with an adaptation
+// field, so every packet it writes is exactly 188 bytes.
+type PacketWriter struct {
+	w          io.Writer
+	continuity map[uint16]uint8
+}
+
+func NewPacketWriter(w io.Writer) *PacketWriter {
+	return &PacketWriter{w, make(map[uint16]uint8)}
+}
+
+// buildAdaptationField returns an adaptation_field() of exactly
+// 1+body_length bytes (the leading adaptation_field_length byte plus its
+// body), carrying pcr as a program_clock_reference if non-nil and padding
+// the rest of the body with stuffing bytes. The encoding is the inverse
+// of parsePCRField/ParseAdaptationField's PCR handling.
+func buildAdaptationField(body_length int, pcr *uint64) []byte {
+	field := make([]byte, 1+body_length)
+	field[0] = byte(body_length)
+	if body_length == 0 {
+		return field
+	}
+	p := 1
+	if pcr != nil {
+		field[1] |= 0x10 // PCR_flag
+		base := *pcr / 300
+		extension := uint16(*pcr % 300)
+		field[p+1] = byte(base >> 25)
+		field[p+2] = byte(base >> 17)
+		field[p+3] = byte(base >> 9)
+		field[p+4] = byte(base >> 1)
+		field[p+5] = byte(base<<7)&0x80 | 0x7e | byte(extension>>8)&0x1
+		field[p+6] = byte(extension)
+		p += 7
+	}
+	for ; p < len(field); p++ {
+		field[p] = 0xff
+	}
+	return field
+}
+
+// WritePacket writes a single 188 byte TS packet carrying payload (at
+// most 184 bytes) on pid, setting payload_unit_start_indicator when start
+// is true and stamping the next continuity_counter for pid. pcr, if
+// non-nil, is carried in the packet's adaptation field as a
+// program_clock_reference (as returned by AdaptationField.PCR()). When
+// payload does not fill the packet, or pcr is given, the remainder is
+// padded with an adaptation field.
+func (pw *PacketWriter) WritePacket(pid uint16, start bool, pcr *uint64, payload []byte) error {
+	if len(payload) > 184 {
+		return fmt.Errorf("payload of %d bytes does not fit in a TS packet", len(payload))
+	}
+
+	cc := pw.continuity[pid]
+	if len(payload) > 0 {
+		pw.continuity[pid] = (cc + 1) & 0xf
+	}
+
+	var af []byte
+	afc := byte(1)
+	if pcr != nil || len(payload) < 184 {
+		afc = 3
+		if len(payload) == 0 {
+			afc = 2
+		}
+		body_length := 183 - len(payload)
+		if pcr != nil && body_length < 7 {
+			return fmt.Errorf("payload of %d bytes leaves no room for a PCR", len(payload))
+		}
+		af = buildAdaptationField(body_length, pcr)
+	}
+
+	packet := make([]byte, 4, 188)
+	packet[0] = 0x47
+	packet[1] = byte(pid >> 8 & 0x1f)
+	if start {
+		packet[1] |= 0x40
+	}
+	packet[2] = byte(pid)
+	packet[3] = afc<<4 | cc
+	packet = append(packet, af...)
+	packet = append(packet, payload...)
+
+	_, e := pw.w.Write(packet)
+	return e
+}
+
+// finishSection fills in a section's common header fields (table_id
+// through last_section_number, ETSI/ISO 13818-1's generic section
+// syntax), bumping version_number by one to signal the change to
+// downstream demuxers, then appends the CRC32 trailer. body must have its
+// first 8 bytes reserved for the header, followed by the table-specific
+// payload.
+func finishSection(table_id uint16, id uint16, version uint8, current_next bool,
+	section_number uint8, last_section_number uint8, body []byte) []byte {
+
+	version = (version + 1) & 0x1f
+	section_length := uint(len(body)) - 3 + CRC32Len
+
+	body[0] = byte(table_id)
+	body[1] = 0xb0 | byte(section_length>>8)
+	body[2] = byte(section_length)
+	body[3] = byte(id >> 8)
+	body[4] = byte(id)
+	body[5] = 0xc0 | version<<1
+	if current_next {
+		body[5] |= 0x1
+	}
+	body[6] = section_number
+	body[7] = last_section_number
+
+	crc := Crc32(body)
+	return append(body, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+// BuildPATSection serializes section back into section bytes (table_id
+// through CRC32), the inverse of NewPATSectionDecoder.
+func BuildPATSection(section *PATSection) []byte {
+	body := make([]byte, PATHeaderLen+uint(len(section.Assotiations))*4)
+	for i, assoc := range section.Assotiations {
+		p := PATHeaderLen + uint(i)*4
+		body[p] = byte(assoc.ProgramNumber >> 8)
+		body[p+1] = byte(assoc.ProgramNumber)
+		body[p+2] = byte(assoc.PID>>8)&0x1f | 0xe0
+		body[p+3] = byte(assoc.PID)
+	}
+	return finishSection(section.TableID, section.TransportStreamID,
+		section.VersionNumber, section.CurrentNext,
+		section.SectionNumber, section.LastSectionNumber, body)
+}
+
+// BuildPMTSection serializes section back into section bytes, the
+// inverse of NewPMTSectionDecoder, re-encoding ProgramInfo/StreamInfo's
+// descriptors via BuildDescriptors.
+func BuildPMTSection(section *PMTSection) []byte {
+	program_info := BuildDescriptors(section.ProgramInfo)
+
+	stream_info := make([]byte, 0)
+	for _, si := range section.StreamInfo {
+		es_info := BuildDescriptors(si.ESInfo)
+		entry := make([]byte, 5, 5+len(es_info))
+		entry[0] = si.StreamType
+		entry[1] = byte(si.ElementaryPID>>8)&0x1f | 0xe0
+		entry[2] = byte(si.ElementaryPID)
+		entry[3] = byte(len(es_info)>>8)&0x0f | 0xf0
+		entry[4] = byte(len(es_info))
+		entry = append(entry, es_info...)
+		stream_info = append(stream_info, entry...)
+	}
+
+	body := make([]byte, 12, 12+len(program_info)+len(stream_info))
+	body[8] = byte(section.PCR_PID>>8)&0x1f | 0xe0
+	body[9] = byte(section.PCR_PID)
+	body[10] = byte(len(program_info)>>8)&0x0f | 0xf0
+	body[11] = byte(len(program_info))
+	body = append(body, program_info...)
+	body = append(body, stream_info...)
+
+	return finishSection(section.TableID, section.TransportStreamID,
+		section.VersionNumber, section.CurrentNext,
+		section.SectionNumber, section.LastSectionNumber, body)
+}
+
+// encodeTimestamp packs a 33bit PTS/DTS value into a 5 byte field tagged
+// with the given 4bit marker ('0010' for PTS-only, '0011' for PTS
+// followed by a DTS, '0001' for that DTS), the inverse of the PTS
+// extraction in PESPacketDecoder.Submit.
+func encodeTimestamp(out []byte, marker byte, ts uint64) {
+	out[0] = marker<<4 | byte(ts>>29)&0xe | 0x1
+	out[1] = byte(ts >> 22)
+	out[2] = byte(ts>>14)&0xfe | 0x1
+	out[3] = byte(ts >> 7)
+	out[4] = byte(ts<<1)&0xfe | 0x1
+}
+
+// buildPESHeader returns a PES packet header (packet_start_code_prefix
+// through the end of any PTS/DTS), sized for a payload of data_length
+// bytes following it.
+func buildPESHeader(stream_id uint8, data_length int, pts uint64, has_pts bool, dts uint64, has_dts bool) []byte {
+	var pts_dts_flags byte
+	var timestamps []byte
+	switch {
+	case has_pts && has_dts:
+		pts_dts_flags = 3
+		timestamps = make([]byte, 10)
+		encodeTimestamp(timestamps[0:5], 0x3, pts)
+		encodeTimestamp(timestamps[5:10], 0x1, dts)
+	case has_pts:
+		pts_dts_flags = 2
+		timestamps = make([]byte, 5)
+		encodeTimestamp(timestamps, 0x2, pts)
+	}
+
+	optional_header := make([]byte, 3+len(timestamps))
+	optional_header[0] = 0x80 // '10' marker bits
+	optional_header[1] = pts_dts_flags << 6
+	optional_header[2] = byte(len(timestamps))
+	copy(optional_header[3:], timestamps)
+
+	pes_packet_length := len(optional_header) + data_length
+	header := make([]byte, 6, 6+len(optional_header))
+	header[0], header[1], header[2] = 0x00, 0x00, 0x01
+	header[3] = stream_id
+	if pes_packet_length <= 0xffff {
+		header[4] = byte(pes_packet_length >> 8)
+		header[5] = byte(pes_packet_length)
+	} // else leave 0: an unbounded length, legal only for video streams.
+	return append(header, optional_header...)
+}
+
+// PESPacketizer builds PES packets for one elementary stream out of
+// access units plus PTS/DTS, and chunks them into TS payloads written
+// through a PacketWriter, the inverse of PESPacketDecoder.Submit.
+type PESPacketizer struct {
+	pw       *PacketWriter
+	pid      uint16
+	StreamID uint8
+}
+
+func NewPESPacketizer(pw *PacketWriter, pid uint16, stream_id uint8) *PESPacketizer {
+	return &PESPacketizer{pw, pid, stream_id}
+}
+
+// WriteAccessUnit packetizes a single ES access unit into one PES packet
+// and writes it, split across as many TS packets as needed. pcr, if
+// non-nil, is carried on the first of those TS packets.
+func (p *PESPacketizer) WriteAccessUnit(data []byte, pts uint64, has_pts bool, dts uint64, has_dts bool, pcr *uint64) error {
+	header := buildPESHeader(p.StreamID, len(data), pts, has_pts, dts, has_dts)
+	packet := append(header, data...)
+
+	start := true
+	for len(packet) > 0 {
+		max := 184
+		var chunk_pcr *uint64
+		if start {
+			chunk_pcr = pcr
+			if pcr != nil {
+				// WritePacket needs body_length >= 7 to fit a PCR's
+				// flags byte + 6 PCR bytes, which costs the payload
+				// the adaptation_field_length byte plus those 7.
+				max = 184 - 8
+			}
+		}
+		n := len(packet)
+		if n > max {
+			n = max
+		}
+		if e := p.pw.WritePacket(p.pid, start, chunk_pcr, packet[:n]); e != nil {
+			return e
+		}
+		packet = packet[n:]
+		start = false
+	}
+	return nil
+}
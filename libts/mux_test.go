@@ -0,0 +1,76 @@
+package libts
+
+import "testing"
+
+// TestAdaptationFieldPCRRoundTrip checks that a PCR built by
+// buildAdaptationField survives ParseAdaptationField unchanged, the bit
+// packing this series' PCR-locked delay mode and remux muxer both rely on.
+func TestAdaptationFieldPCRRoundTrip(t *testing.T) {
+	pcr := uint64(27_000_000) // 1 second of 27MHz clock
+	field := buildAdaptationField(7, &pcr)
+
+	af, e := ParseAdaptationField(field)
+	if e != nil {
+		t.Fatalf("ParseAdaptationField: %v", e)
+	}
+	if !af.PCRFlag {
+		t.Fatal("PCRFlag not set")
+	}
+	if af.PCR() != pcr {
+		t.Fatalf("PCR round trip: got %d, want %d", af.PCR(), pcr)
+	}
+}
+
+// TestAdaptationFieldPCRRoundTripStuffed checks the same round trip when
+// the adaptation field carries stuffing bytes after the PCR, as it does
+// whenever WritePacket pads a short payload out to 188 bytes.
+func TestAdaptationFieldPCRRoundTripStuffed(t *testing.T) {
+	pcr := uint64(300) // base=1, extension=0
+	field := buildAdaptationField(20, &pcr)
+
+	af, e := ParseAdaptationField(field)
+	if e != nil {
+		t.Fatalf("ParseAdaptationField: %v", e)
+	}
+	if af.PCR() != pcr {
+		t.Fatalf("PCR round trip: got %d, want %d", af.PCR(), pcr)
+	}
+}
+
+// TestPESTimestampRoundTrip checks that encodeTimestamp/parsePESTimestamp,
+// the inverse operations used by buildPESHeader and PESPacketDecoder.Submit
+// respectively, agree on a PTS value.
+func TestPESTimestampRoundTrip(t *testing.T) {
+	pts := uint64(1<<33 - 1) // max 33bit value
+	out := make([]byte, 5)
+	encodeTimestamp(out, 0x2, pts)
+
+	if got := parsePESTimestamp(out); got != pts {
+		t.Fatalf("PTS round trip: got %d, want %d", got, pts)
+	}
+}
+
+// TestPESPacketDecoderPTSDTSRoundTrip checks that a PES header built by
+// buildPESHeader (as PESPacketizer.WriteAccessUnit does) is parsed back to
+// the same PTS/DTS by PESPacketDecoder.Submit, the inverse path exercised
+// by remux and delay.
+func TestPESPacketDecoderPTSDTSRoundTrip(t *testing.T) {
+	pts := uint64(5400000)
+	dts := uint64(5390000)
+	payload := []byte{0xde, 0xad, 0xbe, 0xef}
+	header := buildPESHeader(0xe0, len(payload), pts, true, dts, true)
+
+	var got *PESPacketHeader
+	d := NewPESPacketDecoder(func(h *PESPacketHeader) { got = h }, nil)
+	d.Submit(&TSPacket{PayloadUnitStart: true, DataBytes: append(header, payload...)})
+
+	if got == nil {
+		t.Fatal("onHeader not called")
+	}
+	if gotPTS, ok := got.GetPTS(); !ok || gotPTS != pts {
+		t.Fatalf("PTS round trip: got (%d, %v), want %d", gotPTS, ok, pts)
+	}
+	if gotDTS, ok := got.GetDTS(); !ok || gotDTS != dts {
+		t.Fatalf("DTS round trip: got (%d, %v), want %d", gotDTS, ok, dts)
+	}
+}
@@ -0,0 +1,382 @@
+package libts
+
+import (
+	"fmt"
+	"time"
+)
+
+// Well-known PIDs for the DVB/ARIB SI tables decoded in this file.
+// ISO 13818-1 Table 2-3 / ETSI EN 300 468 Table 1.
+const (
+	SDT_PID = 0x0011
+	EIT_PID = 0x0012
+	NIT_PID = 0x0010
+)
+
+const (
+	TableID_SDT_Actual = 0x42
+	TableID_SDT_Other  = 0x46
+
+	TableID_EIT_PresentFollowing_Actual = 0x4e
+	TableID_EIT_PresentFollowing_Other  = 0x4f
+
+	TableID_NIT_Actual = 0x40
+	TableID_NIT_Other  = 0x41
+)
+
+// IsEITScheduleTableID reports whether table_id identifies an EIT schedule
+// (as opposed to present/following) section, i.e. 0x50-0x5f for the actual
+// network or 0x60-0x6f for another one.
+func IsEITScheduleTableID(table_id uint8) bool {
+	return table_id >= 0x50 && table_id <= 0x6f
+}
+
+// TextDecoder converts the raw text bytes carried in SI descriptors (e.g.
+// service_descriptor's name fields, short_event_descriptor's strings) into
+// UTF-8. It is pluggable because the encoding is not self-describing at
+// this layer: ARIB STD-B24 8-unit is the common case for the Japanese
+// streams this package already special-cases elsewhere (the 1seg PID),
+// while DVB streams default to ETSI EN 300 468 Annex A instead.
+type TextDecoder interface {
+	Decode([]byte) string
+}
+
+// ARIB8TextDecoder is the DefaultTextDecoder. It strips the ARIB STD-B24
+// control sequences used to switch between character sets and passes the
+// remaining bytes through one-for-one; it does not map the multi-byte
+// kanji region to Unicode, so text using it will come through mangled.
+// Swap in a fuller implementation via DefaultTextDecoder for correct
+// Japanese text.
+type ARIB8TextDecoder struct{}
+
+func (ARIB8TextDecoder) Decode(data []byte) string {
+	out := make([]rune, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b == 0x1b:
+			// escape sequence selecting a character set: skip its
+			// intermediate bytes and the final byte that follows them.
+			i++
+			for i < len(data) && data[i] >= 0x20 && data[i] <= 0x2f {
+				i++
+			}
+		case b < 0x20 || b == 0x7f:
+			// control code (e.g. newline, string terminator)
+		default:
+			out = append(out, rune(b))
+		}
+	}
+	return string(out)
+}
+
+// DVBTextDecoder decodes the default ETSI EN 300 468 Annex A representation
+// (ISO/IEC 8859-1) and does not interpret a leading code-table-selector
+// byte, so text using another table will come through mangled.
+type DVBTextDecoder struct{}
+
+func (DVBTextDecoder) Decode(data []byte) string {
+	out := make([]rune, len(data))
+	for i, b := range data {
+		out[i] = rune(b)
+	}
+	return string(out)
+}
+
+// DefaultTextDecoder is used by ServiceName/ShortEvent/NetworkName when the
+// caller does not supply its own TextDecoder.
+var DefaultTextDecoder TextDecoder = ARIB8TextDecoder{}
+
+func bcdToInt(b byte) int {
+	return int(b>>4)*10 + int(b&0xf)
+}
+
+// parseMJDDate decodes the 16bit Modified Julian Date used by DVB start_time
+// fields, per ETSI EN 300 468 Annex C.
+func parseMJDDate(mjd int) (year int, month int, day int) {
+	yy := int((float64(mjd) - 15078.2) / 365.25)
+	mm := int((float64(mjd) - 14956.1 - float64(int(float64(yy)*365.25))) / 30.6001)
+	d := mjd - 14956 - int(float64(yy)*365.25) - int(float64(mm)*30.6001)
+	k := 0
+	if mm == 14 || mm == 15 {
+		k = 1
+	}
+	return yy + k + 1900, mm - 1 - k*12, d
+}
+
+// parseDVBTime decodes a 5 byte start_time field (16bit MJD followed by a
+// 24bit BCD UTC time), returning the zero time.Time if it is the
+// "undefined" all-ones value used by NVOD reference events.
+func parseDVBTime(data []byte) time.Time {
+	if data[0] == 0xff && data[1] == 0xff && data[2] == 0xff &&
+		data[3] == 0xff && data[4] == 0xff {
+		return time.Time{}
+	}
+	mjd := int(data[0])<<8 | int(data[1])
+	year, month, day := parseMJDDate(mjd)
+	hour, min, sec := bcdToInt(data[2]), bcdToInt(data[3]), bcdToInt(data[4])
+	return time.Date(year, time.Month(month), day, hour, min, sec, 0, time.UTC)
+}
+
+// parseDVBDuration decodes a 3 byte BCD HHMMSS duration field.
+func parseDVBDuration(data []byte) time.Duration {
+	h, m, s := bcdToInt(data[0]), bcdToInt(data[1]), bcdToInt(data[2])
+	return time.Duration(h)*time.Hour +
+		time.Duration(m)*time.Minute +
+		time.Duration(s)*time.Second
+}
+
+// Service is a single service_id entry of an SDTSection.
+type Service struct {
+	ServiceID               uint16
+	EITScheduleFlag         bool
+	EITPresentFollowingFlag bool
+	RunningStatus           uint8
+	FreeCAMode              bool
+	Descriptors             []Descriptor
+}
+
+func (s *Service) Print() {
+	fmt.Printf("service id: %d, running status: %d\n", s.ServiceID, s.RunningStatus)
+	for _, d := range s.Descriptors {
+		d.Print()
+	}
+}
+
+// ServiceName resolves the ServiceDescriptor attached to this Service, if
+// any, decoding its provider/service name with decoder.
+func (s *Service) ServiceName(decoder TextDecoder) (provider string, name string, ok bool) {
+	for _, d := range s.Descriptors {
+		if service_desc, match := d.(*ServiceDescriptor); match {
+			return decoder.Decode(service_desc.ServiceProviderName),
+				decoder.Decode(service_desc.ServiceName), true
+		}
+	}
+	return "", "", false
+}
+
+// SDTSection is a decoded Service Description Table section (PID 0x0011,
+// table_id 0x42/0x46), mapping service_id to its descriptors.
+// ETSI EN 300 468 5.2.3.
+type SDTSection struct {
+	SectionHeader
+	OriginalNetworkID uint16
+	Services          []Service
+}
+
+func (s *SDTSection) Print() {
+	s.SectionHeader.Print()
+	fmt.Printf("original network id: %d\n", s.OriginalNetworkID)
+	for _, svc := range s.Services {
+		svc.Print()
+	}
+}
+
+func NewSDTSectionDecoder(callback func(*SDTSection)) *SectionDecoder {
+	return NewSectionDecoder(func(buffer []byte) {
+		if len(buffer) < minSectionHeaderLen {
+			callback(&SDTSection{SectionHeader{}, 0, nil})
+			return
+		}
+		header := CreateSectionHeaderFromBuffer(buffer)
+		sec_end := sectionBodyEnd(buffer)
+		if sec_end < 11 {
+			callback(&SDTSection{header, 0, nil})
+			return
+		}
+		original_network_id := uint16(buffer[8])<<8 | uint16(buffer[9])
+		services := make([]Service, 0)
+		for p := uint(11); p+5 <= sec_end; {
+			service_id := uint16(buffer[p])<<8 | uint16(buffer[p+1])
+			flags := buffer[p+2]
+			eit_schedule := flags&0x02 != 0
+			eit_present_following := flags&0x01 != 0
+			running_status := buffer[p+3] >> 5
+			free_ca_mode := buffer[p+3]&0x10 != 0
+			descriptors_loop_length := ReadLength(buffer[p+3:])
+			descriptors_end := clampOffset(p+5+descriptors_loop_length, sec_end)
+			descriptors := ParseDescriptors(buffer[p+5 : descriptors_end])
+			services = append(services, Service{
+				service_id, eit_schedule, eit_present_following,
+				running_status, free_ca_mode, descriptors,
+			})
+			p = descriptors_end
+		}
+		callback(&SDTSection{header, original_network_id, services})
+	})
+}
+
+// Event is a single event_id entry of an EITSection, either a
+// present/following event or one out of the event schedule.
+type Event struct {
+	EventID       uint16
+	StartTime     time.Time
+	Duration      time.Duration
+	RunningStatus uint8
+	FreeCAMode    bool
+	Descriptors   []Descriptor
+}
+
+func (e *Event) Print() {
+	fmt.Printf("event id: %d, start: %s, duration: %s\n",
+		e.EventID, e.StartTime, e.Duration)
+	for _, d := range e.Descriptors {
+		d.Print()
+	}
+}
+
+// ShortEvent resolves the ShortEventDescriptor attached to this Event, if
+// any, decoding its name/description with decoder.
+func (e *Event) ShortEvent(decoder TextDecoder) (name string, description string, ok bool) {
+	for _, d := range e.Descriptors {
+		if short_event, match := d.(*ShortEventDescriptor); match {
+			return decoder.Decode(short_event.EventName),
+				decoder.Decode(short_event.Text), true
+		}
+	}
+	return "", "", false
+}
+
+// EITSection is a decoded Event Information Table section (PID 0x0012,
+// table_id 0x4e/0x4f for present/following, 0x50-0x6f for the schedule).
+// ETSI EN 300 468 5.2.4.
+type EITSection struct {
+	SectionHeader
+	ServiceID                uint16
+	TransportStreamID        uint16
+	OriginalNetworkID        uint16
+	SegmentLastSectionNumber uint8
+	LastTableID              uint8
+	Events                   []Event
+}
+
+func (s *EITSection) Print() {
+	s.SectionHeader.Print()
+	fmt.Printf("service id: %d, transport stream id: %d, original network id: %d\n",
+		s.ServiceID, s.TransportStreamID, s.OriginalNetworkID)
+	for _, e := range s.Events {
+		e.Print()
+	}
+}
+
+func NewEITSectionDecoder(callback func(*EITSection)) *SectionDecoder {
+	return NewSectionDecoder(func(buffer []byte) {
+		if len(buffer) < minSectionHeaderLen {
+			callback(&EITSection{SectionHeader{}, 0, 0, 0, 0, 0, nil})
+			return
+		}
+		header := CreateSectionHeaderFromBuffer(buffer)
+		service_id := header.TransportStreamID
+		sec_end := sectionBodyEnd(buffer)
+		if sec_end < 14 {
+			callback(&EITSection{header, service_id, 0, 0, 0, 0, nil})
+			return
+		}
+		transport_stream_id := uint16(buffer[8])<<8 | uint16(buffer[9])
+		original_network_id := uint16(buffer[10])<<8 | uint16(buffer[11])
+		segment_last_section_number := buffer[12]
+		last_table_id := buffer[13]
+		events := make([]Event, 0)
+		for p := uint(14); p+12 <= sec_end; {
+			event_id := uint16(buffer[p])<<8 | uint16(buffer[p+1])
+			start_time := parseDVBTime(buffer[p+2 : p+7])
+			duration := parseDVBDuration(buffer[p+7 : p+10])
+			running_status := buffer[p+10] >> 5
+			free_ca_mode := buffer[p+10]&0x10 != 0
+			descriptors_loop_length := ReadLength(buffer[p+10:])
+			descriptors_end := clampOffset(p+12+descriptors_loop_length, sec_end)
+			descriptors := ParseDescriptors(buffer[p+12 : descriptors_end])
+			events = append(events, Event{
+				event_id, start_time, duration,
+				running_status, free_ca_mode, descriptors,
+			})
+			p = descriptors_end
+		}
+		callback(&EITSection{
+			header, service_id, transport_stream_id, original_network_id,
+			segment_last_section_number, last_table_id, events,
+		})
+	})
+}
+
+// TransportStream is a single transport stream entry of a NITSection.
+type TransportStream struct {
+	TransportStreamID uint16
+	OriginalNetworkID uint16
+	Descriptors       []Descriptor
+}
+
+func (t *TransportStream) Print() {
+	fmt.Printf("transport stream id: %d, original network id: %d\n",
+		t.TransportStreamID, t.OriginalNetworkID)
+	for _, d := range t.Descriptors {
+		d.Print()
+	}
+}
+
+// NITSection is a decoded Network Information Table section (PID 0x0010,
+// table_id 0x40 for the actual network). ETSI EN 300 468 5.2.1.
+type NITSection struct {
+	SectionHeader
+	NetworkID          uint16
+	NetworkDescriptors []Descriptor
+	TransportStreams   []TransportStream
+}
+
+func (n *NITSection) Print() {
+	n.SectionHeader.Print()
+	fmt.Printf("network id: %d\n", n.NetworkID)
+	for _, d := range n.NetworkDescriptors {
+		d.Print()
+	}
+	for _, ts := range n.TransportStreams {
+		ts.Print()
+	}
+}
+
+// NetworkName resolves the NetworkNameDescriptor attached to this section's
+// network_descriptors, if any, decoding it with decoder.
+func (n *NITSection) NetworkName(decoder TextDecoder) (name string, ok bool) {
+	for _, d := range n.NetworkDescriptors {
+		if network_name, match := d.(*NetworkNameDescriptor); match {
+			return decoder.Decode(network_name.NetworkName), true
+		}
+	}
+	return "", false
+}
+
+func NewNITSectionDecoder(callback func(*NITSection)) *SectionDecoder {
+	return NewSectionDecoder(func(buffer []byte) {
+		if len(buffer) < minSectionHeaderLen {
+			callback(&NITSection{SectionHeader{}, 0, nil, nil})
+			return
+		}
+		header := CreateSectionHeaderFromBuffer(buffer)
+		network_id := header.TransportStreamID
+		sec_end := sectionBodyEnd(buffer)
+		if sec_end < 10 {
+			callback(&NITSection{header, network_id, nil, nil})
+			return
+		}
+		network_descriptors_length := ReadLength(buffer[8:])
+		p := clampOffset(10+network_descriptors_length, sec_end)
+		network_descriptors := ParseDescriptors(buffer[10:p])
+		transport_streams := make([]TransportStream, 0)
+		if p+2 <= sec_end {
+			transport_stream_loop_length := ReadLength(buffer[p:])
+			p += 2
+			end := clampOffset(p+transport_stream_loop_length, sec_end)
+			for p+6 <= end {
+				transport_stream_id := uint16(buffer[p])<<8 | uint16(buffer[p+1])
+				original_network_id := uint16(buffer[p+2])<<8 | uint16(buffer[p+3])
+				descriptors_length := ReadLength(buffer[p+4:])
+				descriptors_end := clampOffset(p+6+descriptors_length, end)
+				descriptors := ParseDescriptors(buffer[p+6 : descriptors_end])
+				transport_streams = append(transport_streams,
+					TransportStream{transport_stream_id, original_network_id, descriptors})
+				p = descriptors_end
+			}
+		}
+		callback(&NITSection{header, network_id, network_descriptors, transport_streams})
+	})
+}
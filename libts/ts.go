@@ -1,6 +1,7 @@
 package libts
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -30,8 +31,18 @@ const (
 
 const (
 	// from ISO 13818-1 p.48 Table 2-29
-	StreamType_H262     = 0x02
-	StreamType_AAC_ADTS = 0x0f
+	StreamType_MPEG1Audio = 0x03
+	StreamType_MPEG2Audio = 0x04
+	StreamType_H262       = 0x02
+	StreamType_AAC_ADTS   = 0x0f
+	StreamType_LATMAAC    = 0x11
+	StreamType_H264       = 0x1b
+	StreamType_HEVC       = 0x24
+	// ATSC A/53 Part 3 6.7.1; DVB instead signals AC-3/E-AC-3 via a
+	// registration_descriptor since these stream_type values collide
+	// with other uses in some profiles.
+	StreamType_AC3  = 0x81
+	StreamType_EAC3 = 0x87
 )
 
 // bigendian crc32
@@ -52,16 +63,247 @@ func ReadLength(buffer []byte) uint {
 	return (uint(buffer[0])<<8 | uint(buffer[1])) & 0xfff
 }
 
+// clampOffset returns the smaller of offset and limit, guarding against a
+// corrupted inner length field (program_info_length, es_info_length, ...)
+// pointing past the end of an already length-bounded section buffer.
+func clampOffset(offset, limit uint) uint {
+	if offset > limit {
+		return limit
+	}
+	return offset
+}
+
+// minSectionHeaderLen is the size of the generic section header
+// (table_id through last_section_number) CreateSectionHeaderFromBuffer
+// reads; callers must check for it before calling that function.
+const minSectionHeaderLen = 8
+
+// sectionBodyEnd returns the offset of a section buffer's CRC32 trailer,
+// i.e. the exclusive end of everything a decoder may read as section body,
+// or 0 if buffer is too short to even hold one.
+func sectionBodyEnd(buffer []byte) uint {
+	if uint(len(buffer)) < CRC32Len {
+		return 0
+	}
+	return uint(len(buffer)) - CRC32Len
+}
+
+const (
+	// PacketSize is the plain TS packet size: a single 0x47-prefixed packet.
+	PacketSize = 188
+	// M2TSPacketSize is a TS packet prefixed with a 4 byte BDAV/M2TS
+	// arrival-time stamp, as found on Blu-ray and some recording formats.
+	M2TSPacketSize = 192
+	// FECPacketSize is a TS packet followed by a 16 byte Reed-Solomon FEC
+	// suffix, as used by some satellite/DVB-S2 captures.
+	FECPacketSize = 204
+)
+
+// DefaultMaxResyncBytes is how far ReadPacket scans forward looking for
+// sync before giving up, mirroring ffmpeg's MAX_RESYNC_SIZE.
+const DefaultMaxResyncBytes = 64 * 1024
+
+const probeSize = 64 * 1024
+const resyncConfirmCount = 3
+
+// ReaderStats reports how lossy the stream PacketReader is reading has
+// been: how many times it had to resynchronize and how many bytes were
+// skipped doing so.
+type ReaderStats struct {
+	ResyncCount  int
+	DroppedBytes int
+}
+
+// PacketReader reads TSPackets out of a plain, M2TS or FEC-suffixed TS
+// stream, resynchronizing on sync-byte loss. Whatever the underlying packet
+// size, ReadPacket always returns a plain 188 byte packet.
 type PacketReader struct {
-	reader io.Reader
+	br             *bufio.Reader
+	packetSize     int
+	prefixLen      int
+	suffixLen      int
+	MaxResyncBytes int
+	Stats          ReaderStats
 }
 
 func NewPacketReader(reader io.Reader) *PacketReader {
-	return &PacketReader{reader}
+	pr := &PacketReader{
+		br:             bufio.NewReaderSize(reader, probeSize+2*FECPacketSize+1),
+		packetSize:     PacketSize,
+		MaxResyncBytes: DefaultMaxResyncBytes,
+	}
+	pr.probe()
+	return pr
+}
+
+// probe inspects the first probeSize bytes to pick the packet size and the
+// offset of the first sync byte: for each candidate size it counts how many
+// of the bytes spaced that size apart are 0x47, and keeps the (size, offset)
+// pair with the highest count.
+func (pr *PacketReader) probe() {
+	data, _ := pr.br.Peek(probeSize)
+	best_size, best_offset, best_score := PacketSize, 0, 0
+	for _, size := range []int{PacketSize, M2TSPacketSize, FECPacketSize} {
+		for offset := 0; offset < size; offset++ {
+			score := 0
+			for pos := offset; pos < len(data); pos += size {
+				if data[pos] == 0x47 {
+					score++
+				}
+			}
+			if score > best_score {
+				best_size, best_offset, best_score = size, offset, score
+			}
+		}
+	}
+	if best_score < resyncConfirmCount {
+		// not enough confidence that there is a periodic sync byte at
+		// all; assume plain 188 byte packets and let ReadPacket
+		// resynchronize as needed.
+		best_size, best_offset = PacketSize, 0
+	}
+	pr.packetSize = best_size
+	switch best_size {
+	case M2TSPacketSize:
+		pr.prefixLen = 4
+	case FECPacketSize:
+		pr.suffixLen = 16
+	}
+	// best_offset is the position of the first packet's own sync byte, but
+	// ReadPacket's first call still has its usual prefixLen bytes (e.g. an
+	// M2TS arrival-time stamp) to discard before that sync byte; only
+	// discard the remainder here so the two don't double-consume it.
+	discard := best_offset - pr.prefixLen
+	if discard > 0 {
+		pr.br.Discard(discard)
+	}
 }
 
-// currentry this is a dummy.
-type AdaptationField []byte
+// resync scans forward, discarding bytes, until it finds a sync byte
+// confirmed by two more sync bytes one packet length apart, or gives up
+// after MaxResyncBytes.
+func (pr *PacketReader) resync() error {
+	dropped := 0
+	window := 2*pr.packetSize + 1
+	for {
+		if dropped >= pr.MaxResyncBytes {
+			return fmt.Errorf(
+				"lost TS sync and failed to resynchronize after dropping %d bytes",
+				dropped)
+		}
+		look, e := pr.br.Peek(window)
+		if e != nil {
+			return e
+		}
+		if look[0] == 0x47 && look[pr.packetSize] == 0x47 &&
+			look[2*pr.packetSize] == 0x47 {
+			break
+		}
+		if _, e := pr.br.Discard(1); e != nil {
+			return e
+		}
+		dropped++
+	}
+	pr.Stats.ResyncCount++
+	pr.Stats.DroppedBytes += dropped
+	return nil
+}
+
+// AdaptationFieldExtension holds the fields of the
+// adaptation_field_extension() as described in ISO 13818-1 2.4.3.5.
+type AdaptationFieldExtension struct {
+	LTWFlag            bool
+	PiecewiseRateFlag  bool
+	SeamlessSpliceFlag bool
+	LTWValid           bool
+	LTWOffset          uint16 // 15bit
+	PiecewiseRate      uint32 // 22bit
+	SpliceType         uint8  // 4bit
+	DTSNextAU          uint64 // 33bit
+}
+
+// AdaptationField is the decoded adaptation_field() of a TS packet
+// (ISO 13818-1 2.4.3.5). PCR/OPCR are kept as the raw 33bit base and
+// 9bit extension so callers can pick the precision (90kHz or 27MHz)
+// they need; use PCR()/OPCR() for the combined 27MHz value.
+type AdaptationField struct {
+	Discontinuity                bool
+	RandomAccess                 bool
+	ElementaryStreamPriority     bool
+	PCRFlag                      bool
+	OPCRFlag                     bool
+	SplicingPointFlag            bool
+	TransportPrivateDataFlag     bool
+	AdaptationFieldExtensionFlag bool
+	PCRBase                      uint64 // 33bit, 90kHz
+	PCRExtension                 uint16 // 9bit, 27MHz
+	OPCRBase                     uint64 // 33bit, 90kHz
+	OPCRExtension                uint16 // 9bit, 27MHz
+	SpliceCountdown              int8
+	PrivateData                  []byte
+	Extension                    *AdaptationFieldExtension
+}
+
+// PCR returns the program_clock_reference as a single 27MHz count,
+// base*300+extension, as specified in ISO 13818-1 2.4.2.
+func (a *AdaptationField) PCR() uint64 {
+	return a.PCRBase*300 + uint64(a.PCRExtension)
+}
+
+// OPCR returns the original_program_clock_reference as a single 27MHz
+// count, base*300+extension.
+func (a *AdaptationField) OPCR() uint64 {
+	return a.OPCRBase*300 + uint64(a.OPCRExtension)
+}
+
+// parsePCRField decodes a 6 byte program_clock_reference field into its
+// 33bit base (90kHz) and 9bit extension (27MHz) parts.
+func parsePCRField(data []byte) (base uint64, extension uint16) {
+	base = uint64(data[0])<<25 | uint64(data[1])<<17 | uint64(data[2])<<9 |
+		uint64(data[3])<<1 | uint64(data[4])>>7
+	extension = uint16(data[4])&0x1<<8 | uint16(data[5])
+	return
+}
+
+func parseAdaptationFieldExtension(data []byte) (*AdaptationFieldExtension, error) {
+	if len(data) < 1 {
+		return nil, errors.New("adaptation field extension is too short")
+	}
+	ext := &AdaptationFieldExtension{
+		LTWFlag:            data[0]&0x80 != 0,
+		PiecewiseRateFlag:  data[0]&0x40 != 0,
+		SeamlessSpliceFlag: data[0]&0x20 != 0,
+	}
+	p := 1
+	if ext.LTWFlag {
+		if len(data) < p+2 {
+			return nil, errors.New("adaptation field extension is too short for ltw")
+		}
+		ext.LTWValid = data[p]&0x80 != 0
+		ext.LTWOffset = uint16(data[p])&0x7f<<8 | uint16(data[p+1])
+		p += 2
+	}
+	if ext.PiecewiseRateFlag {
+		if len(data) < p+3 {
+			return nil, errors.New("adaptation field extension is too short for piecewise rate")
+		}
+		ext.PiecewiseRate = uint32(data[p])&0x3f<<16 | uint32(data[p+1])<<8 | uint32(data[p+2])
+		p += 3
+	}
+	if ext.SeamlessSpliceFlag {
+		if len(data) < p+5 {
+			return nil, errors.New("adaptation field extension is too short for seamless splice")
+		}
+		ext.SpliceType = data[p] >> 4
+		ext.DTSNextAU = uint64(data[p])&0xe<<29 |
+			uint64(data[p+1])<<22 |
+			uint64(data[p+2])&0xfe<<14 |
+			uint64(data[p+3])<<7 |
+			uint64(data[p+4])>>1
+		p += 5
+	}
+	return ext, nil
+}
 
 type TSPacket struct {
 	SyncByte                   uint8
@@ -78,7 +320,78 @@ type TSPacket struct {
 }
 
 func ParseAdaptationField(data []byte) (AdaptationField, error) {
-	return data, nil
+	var af AdaptationField
+	if len(data) < 1 {
+		return af, errors.New("adaptation field is empty")
+	}
+	length := data[0]
+	if length == 0 {
+		// adaptation_field_length == 0 means nothing but stuffing bytes.
+		return af, nil
+	}
+	if len(data) < 2 {
+		return af, errors.New("adaptation field is too short")
+	}
+	flags := data[1]
+	af.Discontinuity = flags&0x80 != 0
+	af.RandomAccess = flags&0x40 != 0
+	af.ElementaryStreamPriority = flags&0x20 != 0
+	af.PCRFlag = flags&0x10 != 0
+	af.OPCRFlag = flags&0x08 != 0
+	af.SplicingPointFlag = flags&0x04 != 0
+	af.TransportPrivateDataFlag = flags&0x02 != 0
+	af.AdaptationFieldExtensionFlag = flags&0x01 != 0
+
+	p := 2
+	if af.PCRFlag {
+		if len(data) < p+6 {
+			return af, errors.New("adaptation field is too short for PCR")
+		}
+		af.PCRBase, af.PCRExtension = parsePCRField(data[p:])
+		p += 6
+	}
+	if af.OPCRFlag {
+		if len(data) < p+6 {
+			return af, errors.New("adaptation field is too short for OPCR")
+		}
+		af.OPCRBase, af.OPCRExtension = parsePCRField(data[p:])
+		p += 6
+	}
+	if af.SplicingPointFlag {
+		if len(data) < p+1 {
+			return af, errors.New("adaptation field is too short for splice countdown")
+		}
+		af.SpliceCountdown = int8(data[p])
+		p++
+	}
+	if af.TransportPrivateDataFlag {
+		if len(data) < p+1 {
+			return af, errors.New("adaptation field is too short for private data length")
+		}
+		private_data_length := int(data[p])
+		p++
+		if len(data) < p+private_data_length {
+			return af, errors.New("adaptation field is too short for private data")
+		}
+		af.PrivateData = data[p : p+private_data_length]
+		p += private_data_length
+	}
+	if af.AdaptationFieldExtensionFlag {
+		if len(data) < p+1 {
+			return af, errors.New("adaptation field is too short for extension length")
+		}
+		extension_length := int(data[p])
+		if len(data) < p+1+extension_length {
+			return af, errors.New("adaptation field is too short for extension")
+		}
+		ext, e := parseAdaptationFieldExtension(data[p+1 : p+1+extension_length])
+		if e != nil {
+			return af, e
+		}
+		af.Extension = ext
+		p += 1 + extension_length
+	}
+	return af, nil
 }
 
 func ParsePacket(data []byte) (*TSPacket, error) {
@@ -118,10 +431,29 @@ func (tsp *TSPacket) HasDataBytes() bool {
 }
 
 func (pr *PacketReader) ReadPacket() (*TSPacket, error) {
+	if pr.prefixLen > 0 {
+		if _, e := pr.br.Discard(pr.prefixLen); e != nil {
+			return nil, e
+		}
+	}
+	b, e := pr.br.Peek(1)
+	if e != nil {
+		return nil, e
+	}
+	if b[0] != 0x47 {
+		if e := pr.resync(); e != nil {
+			return nil, e
+		}
+	}
 	buf := make([]byte, 188)
-	if _, e := io.ReadFull(pr.reader, buf); e != nil {
+	if _, e := io.ReadFull(pr.br, buf); e != nil {
 		return nil, e
 	}
+	if pr.suffixLen > 0 {
+		if _, e := pr.br.Discard(pr.suffixLen); e != nil {
+			return nil, e
+		}
+	}
 	return ParsePacket(buf)
 }
 
@@ -229,7 +561,21 @@ const CRC32Len uint = 4
 
 func NewPATSectionDecoder(callback func(*PATSection)) *SectionDecoder {
 	return NewSectionDecoder(func(buffer []byte) {
-		assoc_len := (uint(len(buffer)) - PATHeaderLen - CRC32Len) / 4
+		if len(buffer) < minSectionHeaderLen {
+			// too short to even hold the generic section header; bail
+			// out rather than let CreateSectionHeaderFromBuffer index
+			// out of range.
+			callback(&PATSection{SectionHeader{}, nil})
+			return
+		}
+		sec_end := sectionBodyEnd(buffer)
+		if sec_end < PATHeaderLen {
+			// too short to even hold the fixed PAT header; bail out with
+			// an empty section rather than underflowing assoc_len.
+			callback(&PATSection{CreateSectionHeaderFromBuffer(buffer), nil})
+			return
+		}
+		assoc_len := (sec_end - PATHeaderLen) / 4
 		assocs := make([]ProgramAssotiation, assoc_len)
 		for i := uint(0); i < assoc_len; i++ {
 			p := PATHeaderLen + i*4
@@ -246,12 +592,9 @@ func NewPATSectionDecoder(callback func(*PATSection)) *SectionDecoder {
 
 type Descriptor interface {
 	Print()
-}
-
-type DummyDescriptor struct{}
-
-func (d *DummyDescriptor) Print() {
-	fmt.Printf("dummy descriptor\n")
+	// Bytes serializes the descriptor back to its tag/length/data form,
+	// the inverse of ParseDescriptors.
+	Bytes() []byte
 }
 
 type StreamInfo struct {
@@ -292,21 +635,36 @@ func (p *PMTSection) Print() {
 
 func NewPMTSectionDecoder(callback func(*PMTSection)) *SectionDecoder {
 	return NewSectionDecoder(func(buffer []byte) {
+		if len(buffer) < minSectionHeaderLen {
+			// too short to even hold the generic section header; bail
+			// out rather than let CreateSectionHeaderFromBuffer index
+			// out of range.
+			callback(&PMTSection{SectionHeader{}, 0, nil, nil})
+			return
+		}
+		sec_end := sectionBodyEnd(buffer)
+		if sec_end < 12 {
+			// too short to even hold the fixed PMT header; bail out with
+			// an empty section rather than slicing out of range.
+			callback(&PMTSection{CreateSectionHeaderFromBuffer(buffer), 0, nil, nil})
+			return
+		}
 		pcr_pid := ReadPID(buffer[8:])
 		program_info_length := ReadLength(buffer[10:])
-		// todo decode program info
-		program_info := make([]Descriptor, 0)
+		program_info_end := clampOffset(12+program_info_length, sec_end)
+		program_info := ParseDescriptors(buffer[12:program_info_end])
 		stream_info := make([]StreamInfo, 0)
-		for p := 12 + program_info_length; p < uint(len(buffer))-CRC32Len; {
+		for p := program_info_end; p+5 <= sec_end; {
 			es_info_length := ReadLength(buffer[p+3:])
-			es_info := make([]Descriptor, 0)
+			es_info_end := clampOffset(p+5+es_info_length, sec_end)
+			es_info := ParseDescriptors(buffer[p+5 : es_info_end])
 			stream_info = append(stream_info,
 				StreamInfo{
 					uint8(buffer[p]),
 					ReadPID(buffer[p+1:]),
 					es_info,
 				})
-			p += 5 + es_info_length
+			p = es_info_end
 		}
 		sec := &PMTSection{
 			CreateSectionHeaderFromBuffer(buffer),
@@ -318,14 +676,67 @@ func NewPMTSectionDecoder(callback func(*PMTSection)) *SectionDecoder {
 	})
 }
 
-//Currently, this does not suport full specification.
+// PESPacketHeader is the decoded optional PES header (ISO 13818-1 2.4.3.7).
+// PES_extension() is not parsed, since nothing here needs it.
 type PESPacketHeader struct {
 	PacketStartCodePrefix  uint32
 	StreamID               uint8
 	PESPacketLength        uint16
+	ScramblingControl      uint8 // 2bit
+	Priority               bool
 	DataAlignmentIndicator bool
+	Copyright              bool
+	OriginalOrCopy         bool
+	ESCRFlag               bool
+	ESCRBase               uint64 // 33bit, 90kHz
+	ESCRExtension          uint16 // 9bit, 27MHz
+	ESRateFlag             bool
+	ESRate                 uint32 // 22bit, units of 50 bytes/second
+	DSMTrickModeFlag       bool
+	DSMTrickMode           uint8
+	AdditionalCopyInfoFlag bool
+	AdditionalCopyInfo     uint8 // 7bit
+	PESCRCFlag             bool
+	PESCRC                 uint16
 	pts_dts_flags          uint8
 	pts                    uint64
+	dts                    uint64
+}
+
+// ESCR returns the elementary_stream_clock_reference as a single 27MHz
+// count, base*300+extension, mirroring AdaptationField.PCR().
+func (p *PESPacketHeader) ESCR() uint64 {
+	return p.ESCRBase*300 + uint64(p.ESCRExtension)
+}
+
+// parsePESTimestamp decodes a 5 byte PTS or DTS field, the format shared
+// by both (ISO 13818-1 2.4.3.7).
+func parsePESTimestamp(data []byte) uint64 {
+	ts := uint64(data[0]) & 0xe << 29
+	ts |= uint64(data[1]) << 22
+	ts |= uint64(data[2]) & 0xfe << 14
+	ts |= uint64(data[3]) << 7
+	ts |= uint64(data[4]) >> 1
+	return ts
+}
+
+// parseESCR decodes a 6 byte ESCR field into its 33bit base (90kHz) and
+// 9bit extension (27MHz) parts, the PES counterpart of parsePCRField.
+func parseESCR(data []byte) (base uint64, extension uint16) {
+	base = uint64(data[0])&0x38<<27 |
+		uint64(data[0])&0x3<<28 |
+		uint64(data[1])<<20 |
+		uint64(data[2])&0xf8<<12 |
+		uint64(data[2])&0x3<<13 |
+		uint64(data[3])<<5 |
+		uint64(data[4])&0xf8>>3
+	extension = uint16(data[4])&0x3<<7 | uint16(data[5])>>1
+	return
+}
+
+// parseESRate decodes a 3 byte ES_rate field into its 22bit value.
+func parseESRate(data []byte) uint32 {
+	return uint32(data[0])&0x7f<<15 | uint32(data[1])<<7 | uint32(data[2])>>1
 }
 
 const PESPacketMustHeaderLength = 9
@@ -370,25 +781,122 @@ func (d *PESPacketDecoder) Submit(packet *TSPacket) {
 		start_code_prerix := uint32(d.buffer[0])<<16 | uint32(d.buffer[1])<<8 | uint32(d.buffer[2])
 		stream_id := d.buffer[3]
 		packet_len := uint16(ReadLength(d.buffer[4:]))
+		scrambling_control := d.buffer[6] >> 4 & 0x3
+		priority := d.buffer[6]&0x8 > 0
 		data_aligned := d.buffer[6]&0x4 > 0
-		pts_dts_flags := d.buffer[7] >> 6
+		copyright := d.buffer[6]&0x2 > 0
+		original_or_copy := d.buffer[6]&0x1 > 0
+		flags := d.buffer[7]
+		pts_dts_flags := flags >> 6
+		escr_flag := flags&0x20 > 0
+		es_rate_flag := flags&0x10 > 0
+		dsm_trick_mode_flag := flags&0x08 > 0
+		additional_copy_info_flag := flags&0x04 > 0
+		pes_crc_flag := flags&0x02 > 0
+
+		// header_end bounds how far p may advance: pes_header_data_len
+		// and the flag bits are independently corruptible, so a flag
+		// can claim a field that pes_header_data_len leaves no room
+		// for. Once that happens, stop trusting the remaining flags
+		// too (their bit position may itself be wrong) and degrade to
+		// a header with only the fields that actually fit, rather
+		// than indexing d.buffer out of range.
+		header_end := pes_header_data_len + 9
 		pts := uint64(0)
+		dts := uint64(0)
 		p := 9
+		truncated := false
 		if pts_dts_flags >= 2 {
-			pts = uint64(d.buffer[p]) & 0xe << 29
-			pts |= uint64(d.buffer[p+1]) << 22
-			pts |= uint64(d.buffer[p+2]) & 0xfe << 14
-			pts |= uint64(d.buffer[p+3]) << 7
-			pts |= uint64(d.buffer[p+4]) >> 1
-			p += 5
+			if p+5 > header_end {
+				truncated = true
+				pts_dts_flags = 0
+			} else {
+				pts = parsePESTimestamp(d.buffer[p:])
+				p += 5
+			}
+		}
+		if !truncated && pts_dts_flags == 3 {
+			if p+5 > header_end {
+				truncated = true
+				pts_dts_flags = 2
+			} else {
+				dts = parsePESTimestamp(d.buffer[p:])
+				p += 5
+			}
+		}
+		var escr_base uint64
+		var escr_extension uint16
+		if !truncated && escr_flag {
+			if p+6 > header_end {
+				truncated = true
+				escr_flag = false
+			} else {
+				escr_base, escr_extension = parseESCR(d.buffer[p:])
+				p += 6
+			}
+		}
+		var es_rate uint32
+		if !truncated && es_rate_flag {
+			if p+3 > header_end {
+				truncated = true
+				es_rate_flag = false
+			} else {
+				es_rate = parseESRate(d.buffer[p:])
+				p += 3
+			}
+		}
+		var dsm_trick_mode uint8
+		if !truncated && dsm_trick_mode_flag {
+			if p+1 > header_end {
+				truncated = true
+				dsm_trick_mode_flag = false
+			} else {
+				dsm_trick_mode = d.buffer[p]
+				p += 1
+			}
+		}
+		var additional_copy_info uint8
+		if !truncated && additional_copy_info_flag {
+			if p+1 > header_end {
+				truncated = true
+				additional_copy_info_flag = false
+			} else {
+				additional_copy_info = d.buffer[p] & 0x7f
+				p += 1
+			}
+		}
+		var pes_crc uint16
+		if !truncated && pes_crc_flag {
+			if p+2 > header_end {
+				pes_crc_flag = false
+			} else {
+				pes_crc = uint16(d.buffer[p])<<8 | uint16(d.buffer[p+1])
+				p += 2
+			}
 		}
 		header := &PESPacketHeader{
 			start_code_prerix,
 			stream_id,
 			packet_len,
+			scrambling_control,
+			priority,
 			data_aligned,
+			copyright,
+			original_or_copy,
+			escr_flag,
+			escr_base,
+			escr_extension,
+			es_rate_flag,
+			es_rate,
+			dsm_trick_mode_flag,
+			dsm_trick_mode,
+			additional_copy_info_flag,
+			additional_copy_info,
+			pes_crc_flag,
+			pes_crc,
 			pts_dts_flags,
 			pts,
+			dts,
 		}
 		if d.onHeader != nil {
 			d.onHeader(header)
@@ -405,3 +913,7 @@ func (d *PESPacketDecoder) Submit(packet *TSPacket) {
 func (p *PESPacketHeader) GetPTS() (uint64, bool) {
 	return p.pts, p.pts_dts_flags >= 2
 }
+
+func (p *PESPacketHeader) GetDTS() (uint64, bool) {
+	return p.dts, p.pts_dts_flags == 3
+}
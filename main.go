@@ -5,12 +5,14 @@ import (
 	"os"
 
 	"./delay"
+	"./info"
+	"./remux"
 	"./split"
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("select delay or split subcommand")
+		fmt.Println("select delay, split, info or remux subcommand")
 		os.Exit(1)
 	}
 	switch os.Args[1] {
@@ -18,5 +20,9 @@ func main() {
 		delay.Main(os.Args[2:])
 	case "split":
 		split.Main(os.Args[2:])
+	case "info":
+		info.Main(os.Args[2:])
+	case "remux":
+		remux.Main(os.Args[2:])
 	}
 }
@@ -0,0 +1,323 @@
+package remux
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"../libts"
+)
+
+func parsePIDMap(s string) map[uint16]uint16 {
+	m := make(map[uint16]uint16)
+	if s == "" {
+		return m
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			log.Fatalf("invalid -map-pid entry %q, want old:new", pair)
+		}
+		old_pid, e := strconv.ParseUint(kv[0], 0, 16)
+		if e != nil {
+			log.Fatal(e)
+		}
+		new_pid, e := strconv.ParseUint(kv[1], 0, 16)
+		if e != nil {
+			log.Fatal(e)
+		}
+		m[uint16(old_pid)] = uint16(new_pid)
+	}
+	return m
+}
+
+func parsePIDSet(s string) map[uint16]struct{} {
+	m := make(map[uint16]struct{})
+	if s == "" {
+		return m
+	}
+	for _, v := range strings.Split(s, ",") {
+		pid, e := strconv.ParseUint(v, 0, 16)
+		if e != nil {
+			log.Fatal(e)
+		}
+		m[uint16(pid)] = struct{}{}
+	}
+	return m
+}
+
+func mapPID(pid_map map[uint16]uint16, pid uint16) uint16 {
+	if mapped, ok := pid_map[pid]; ok {
+		return mapped
+	}
+	return pid
+}
+
+// patContentKey encodes assocs into a comparable byte string, used to
+// detect whether a retransmitted PAT actually changed.
+func patContentKey(assocs []libts.ProgramAssotiation) string {
+	key := make([]byte, 0, 4*len(assocs))
+	for _, a := range assocs {
+		key = append(key, byte(a.ProgramNumber>>8), byte(a.ProgramNumber),
+			byte(a.PID>>8), byte(a.PID))
+	}
+	return string(key)
+}
+
+// pmtContentKey encodes a PMT's PCR PID and descriptor/stream info into a
+// comparable byte string, used to detect whether a retransmitted PMT
+// actually changed.
+func pmtContentKey(pcr_pid uint16, program_info []libts.Descriptor, stream_info []libts.StreamInfo) string {
+	key := []byte{byte(pcr_pid >> 8), byte(pcr_pid)}
+	key = append(key, libts.BuildDescriptors(program_info)...)
+	for _, si := range stream_info {
+		key = append(key, si.StreamType, byte(si.ElementaryPID>>8), byte(si.ElementaryPID))
+		key = append(key, libts.BuildDescriptors(si.ESInfo)...)
+	}
+	return string(key)
+}
+
+// streamRemuxer re-packetizes one elementary stream through a fresh
+// PESPacketizer, shifting every PTS/DTS by pts_offset (90kHz units). A
+// PCR packet observed on the stream's original PID is carried over onto
+// the first TS packet of whichever access unit is emitted next, since
+// shifting it in lockstep with every other PID's timeline is out of
+// scope here.
+type streamRemuxer struct {
+	decoder    *libts.PESPacketDecoder
+	packetizer *libts.PESPacketizer
+	pts_offset uint64
+
+	header *libts.PESPacketHeader
+	data   []byte
+	pcr    *uint64
+}
+
+func newStreamRemuxer(pw *libts.PacketWriter, pid uint16, pts_offset uint64) *streamRemuxer {
+	r := &streamRemuxer{pts_offset: pts_offset}
+	r.packetizer = libts.NewPESPacketizer(pw, pid, 0)
+	r.decoder = libts.NewPESPacketDecoder(
+		func(header *libts.PESPacketHeader) {
+			r.flush()
+			r.header = header
+			r.data = nil
+		},
+		func(data []byte) {
+			r.data = append(r.data, data...)
+		})
+	return r
+}
+
+func (r *streamRemuxer) submit(packet *libts.TSPacket) {
+	if packet.HasAdaptationField() && packet.AdaptationField.PCRFlag {
+		pcr := packet.AdaptationField.PCR()
+		r.pcr = &pcr
+	}
+	r.decoder.Submit(packet)
+}
+
+func (r *streamRemuxer) flush() {
+	if r.header == nil {
+		return
+	}
+	r.packetizer.StreamID = r.header.StreamID
+	pts, has_pts := r.header.GetPTS()
+	if has_pts {
+		pts += r.pts_offset
+	}
+	dts, has_dts := r.header.GetDTS()
+	if has_dts {
+		dts += r.pts_offset
+	}
+	if e := r.packetizer.WriteAccessUnit(r.data, pts, has_pts, dts, has_dts, r.pcr); e != nil {
+		log.Print(e)
+	}
+	r.pcr = nil
+}
+
+// writeSection writes section (as produced by BuildPATSection/
+// BuildPMTSection) to pid, prefixed by the 1 byte pointer_field
+// SectionDecoder expects on the first packet of a section.
+func writeSection(pw *libts.PacketWriter, pid uint16, section []byte) {
+	payload := append([]byte{0}, section...)
+	start := true
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > 184 {
+			n = 184
+		}
+		if e := pw.WritePacket(pid, start, nil, payload[:n]); e != nil {
+			log.Fatal(e)
+		}
+		payload = payload[n:]
+		start = false
+	}
+}
+
+// Main implements the "remux" subcommand: it reads a TS file and writes a
+// fully regenerated one, optionally rewriting PIDs, dropping streams, or
+// shifting PTS/DTS.
+func Main(args []string) {
+	commandLine := flag.NewFlagSet("remux", flag.ExitOnError)
+	var map_pid_flag, drop_pid_flag string
+	var pts_offset_seconds float64
+	commandLine.StringVar(&map_pid_flag, "map-pid", "",
+		"comma separated old:new PID rewrites, e.g. 256:4096,257:4097")
+	commandLine.StringVar(&drop_pid_flag, "drop-pid", "",
+		"comma separated (post -map-pid) PIDs to drop from the output")
+	commandLine.Float64Var(&pts_offset_seconds, "pts-offset", 0,
+		"seconds to add to every elementary stream's PTS/DTS, applied by repacketizing its PES")
+	commandLine.Parse(args)
+	args = commandLine.Args()
+	if len(args) > 2 {
+		log.Fatal("Invalid number of arguments")
+	}
+	inf := os.Stdin
+	outf := os.Stdout
+	if len(args) >= 1 && args[0] != "-" {
+		var e error
+		inf, e = os.Open(args[0])
+		if e != nil {
+			log.Fatal(e)
+		}
+		defer inf.Close()
+	}
+	if len(args) >= 2 && args[1] != "-" {
+		var e error
+		outf, e = os.Create(args[1])
+		if e != nil {
+			log.Fatal(e)
+		}
+		defer outf.Close()
+	}
+	in := bufio.NewReader(inf)
+	out := bufio.NewWriter(outf)
+	defer out.Flush()
+
+	pid_map := parsePIDMap(map_pid_flag)
+	drop_pids := parsePIDSet(drop_pid_flag)
+	pts_offset := uint64(int64(pts_offset_seconds * 90000))
+
+	pw := libts.NewPacketWriter(out)
+	pmt_pids := make(map[uint16]struct{})
+	pmtds := make(map[uint16]*libts.SectionDecoder)
+	streams := make(map[uint16]*streamRemuxer)
+
+	var have_pat bool
+	var pat_key string
+	var pat_version uint8 // last version_number actually written to output
+	var pat_bytes []byte
+	patd := libts.NewPATSectionDecoder(func(sec *libts.PATSection) {
+		assocs := make([]libts.ProgramAssotiation, 0, len(sec.Assotiations))
+		for _, assoc := range sec.Assotiations {
+			if assoc.ProgramNumber != 0 {
+				pmt_pids[assoc.PID] = struct{}{}
+			}
+			new_pid := mapPID(pid_map, assoc.PID)
+			if _, dropped := drop_pids[new_pid]; dropped {
+				continue
+			}
+			assocs = append(assocs, libts.ProgramAssotiation{ProgramNumber: assoc.ProgramNumber, PID: new_pid})
+		}
+		key := patContentKey(assocs)
+		if !have_pat || key != pat_key {
+			seed := pat_version
+			if !have_pat {
+				seed = sec.VersionNumber - 1
+			}
+			out_sec := *sec
+			out_sec.VersionNumber = seed
+			out_sec.Assotiations = assocs
+			pat_bytes = libts.BuildPATSection(&out_sec)
+			pat_version = (seed + 1) & 0x1f
+			pat_key = key
+			have_pat = true
+		}
+		writeSection(pw, libts.PAT_PID, pat_bytes)
+	})
+
+	pr := libts.NewPacketReader(in)
+	for {
+		packet, e := pr.ReadPacket()
+		if e != nil {
+			break
+		}
+
+		if packet.PID == libts.PAT_PID {
+			patd.Submit(packet)
+			continue
+		}
+
+		if _, is_pmt := pmt_pids[packet.PID]; is_pmt {
+			pmtd, ok := pmtds[packet.PID]
+			if !ok {
+				out_pid := mapPID(pid_map, packet.PID)
+				var have_pmt bool
+				var pmt_key string
+				var pmt_version uint8 // last version_number actually written to output
+				var pmt_bytes []byte
+				pmtd = libts.NewPMTSectionDecoder(func(sec *libts.PMTSection) {
+					stream_info := make([]libts.StreamInfo, 0, len(sec.StreamInfo))
+					for _, si := range sec.StreamInfo {
+						si.ElementaryPID = mapPID(pid_map, si.ElementaryPID)
+						if _, dropped := drop_pids[si.ElementaryPID]; dropped {
+							continue
+						}
+						stream_info = append(stream_info, si)
+					}
+					pcr_pid := mapPID(pid_map, sec.PCR_PID)
+					key := pmtContentKey(pcr_pid, sec.ProgramInfo, stream_info)
+					if !have_pmt || key != pmt_key {
+						seed := pmt_version
+						if !have_pmt {
+							seed = sec.VersionNumber - 1
+						}
+						out_sec := *sec
+						out_sec.VersionNumber = seed
+						out_sec.PCR_PID = pcr_pid
+						out_sec.StreamInfo = stream_info
+						pmt_bytes = libts.BuildPMTSection(&out_sec)
+						pmt_version = (seed + 1) & 0x1f
+						pmt_key = key
+						have_pmt = true
+					}
+					writeSection(pw, out_pid, pmt_bytes)
+				})
+				pmtds[packet.PID] = pmtd
+			}
+			pmtd.Submit(packet)
+			continue
+		}
+
+		new_pid := mapPID(pid_map, packet.PID)
+		if _, dropped := drop_pids[new_pid]; dropped {
+			continue
+		}
+
+		if pts_offset != 0 {
+			remuxer, ok := streams[packet.PID]
+			if !ok {
+				remuxer = newStreamRemuxer(pw, new_pid, pts_offset)
+				streams[packet.PID] = remuxer
+			}
+			remuxer.submit(packet)
+			continue
+		}
+
+		var pcr *uint64
+		if packet.HasAdaptationField() && packet.AdaptationField.PCRFlag {
+			v := packet.AdaptationField.PCR()
+			pcr = &v
+		}
+		if e := pw.WritePacket(new_pid, packet.PayloadUnitStart, pcr, packet.DataBytes); e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	for _, remuxer := range streams {
+		remuxer.flush()
+	}
+}
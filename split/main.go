@@ -1,13 +1,14 @@
-package main
+package split
 
 import (
-	"./libts"
 	"bufio"
 	"bytes"
 	"flag"
 	"io"
 	"log"
 	"os"
+
+	"../libts"
 )
 
 const oneseg_pid = 0x1fc8
@@ -40,7 +41,44 @@ func writeall(w io.Writer, data []byte) error {
 	return nil
 }
 
-func findKeepPID(reader io.Reader) (keep_pids PIDSet, err error) {
+// findServiceID scans reader's SDT for a service whose service_descriptor
+// name decodes to name, returning its service_id (which doubles as the
+// program_number used to look it up in the PAT).
+func findServiceID(reader io.Reader, name string) (service_id uint16, err error) {
+	found := false
+	sdtd := libts.NewSDTSectionDecoder(func(sec *libts.SDTSection) {
+		if found {
+			return
+		}
+		for i := range sec.Services {
+			svc := &sec.Services[i]
+			if _, svc_name, ok := svc.ServiceName(libts.DefaultTextDecoder); ok && svc_name == name {
+				service_id = svc.ServiceID
+				found = true
+				return
+			}
+		}
+	})
+
+	pr := libts.NewPacketReader(reader)
+	for !found {
+		packet, e := pr.ReadPacket()
+		if e != nil {
+			err = e
+			return
+		}
+		if packet.PID == libts.SDT_PID {
+			sdtd.Submit(packet)
+		}
+	}
+	return
+}
+
+// findKeepPID locates the PIDs to keep (PAT, the selected program's PMT,
+// PCR and elementary streams). When service_id is non-zero, only the
+// program whose program_number matches it is kept; otherwise every
+// non-1seg program is kept.
+func findKeepPID(reader io.Reader, service_id uint16) (keep_pids PIDSet, err error) {
 	pmt_pids := NewPIDSet()
 	done_pmts := NewPIDSet()
 	keep_pids = NewPIDSet()
@@ -62,11 +100,18 @@ func findKeepPID(reader io.Reader) (keep_pids PIDSet, err error) {
 		for _, assoc := range sec.Assotiations {
 			if assoc.ProgramNumber == 0 {
 				keep_pids.add(assoc.PID)
-			} else if assoc.PID != oneseg_pid {
-				if !pmt_pids.find(assoc.PID) {
-					pmt_pids.add(assoc.PID)
-					pmtds[assoc.PID] = new_pmtsd(assoc.PID)
+				continue
+			}
+			if service_id != 0 {
+				if assoc.ProgramNumber != service_id {
+					continue
 				}
+			} else if assoc.PID == oneseg_pid {
+				continue
+			}
+			if !pmt_pids.find(assoc.PID) {
+				pmt_pids.add(assoc.PID)
+				pmtds[assoc.PID] = new_pmtsd(assoc.PID)
 			}
 		}
 	})
@@ -122,15 +167,15 @@ func dump_pat(out io.Writer, pat *libts.TSPacket, keep_pids PIDSet) error {
 	return writeall(out, pat.RawData)
 }
 
-func dump_ts(keep_pids PIDSet, in io.Reader, out io.Writer) error {
+func dump_ts(keep_pids PIDSet, in io.Reader, out io.Writer) (libts.ReaderStats, error) {
 	pr := libts.NewPacketReader(in)
 	for {
 		packet, e := pr.ReadPacket()
 		if e != nil {
 			if e == io.EOF {
-				return nil
+				return pr.Stats, nil
 			}
-			return e
+			return pr.Stats, e
 		}
 		if packet.PID == libts.PAT_PID {
 			e = dump_pat(out, packet, keep_pids)
@@ -138,14 +183,18 @@ func dump_ts(keep_pids PIDSet, in io.Reader, out io.Writer) error {
 			e = writeall(out, packet.RawData)
 		}
 		if e != nil {
-			return e
+			return pr.Stats, e
 		}
 	}
 }
 
-func main() {
-	flag.Parse()
-	args := flag.Args()
+func Main(args []string) {
+	commandLine := flag.NewFlagSet("split", flag.ExitOnError)
+	var service_name string
+	commandLine.StringVar(&service_name, "service-name", "",
+		"keep only the program whose SDT service name matches this, instead of every non-1seg one")
+	commandLine.Parse(args)
+	args = commandLine.Args()
 	if len(args) > 2 {
 		log.Fatal("Invalid number of arguments")
 	}
@@ -171,15 +220,32 @@ func main() {
 	out := bufio.NewWriter(outf)
 	defer out.Flush()
 
+	var service_id uint16
+	var pass_in io.Reader = in
+	if service_name != "" {
+		buffered := new(bytes.Buffer)
+		ahead_in := io.TeeReader(in, buffered)
+		var e error
+		service_id, e = findServiceID(ahead_in, service_name)
+		if e != nil {
+			log.Fatal(e)
+		}
+		pass_in = io.MultiReader(buffered, in)
+	}
+
 	buffered := new(bytes.Buffer)
-	ahead_in := io.TeeReader(in, buffered)
-	keep_pids, e := findKeepPID(ahead_in)
+	ahead_in := io.TeeReader(pass_in, buffered)
+	keep_pids, e := findKeepPID(ahead_in, service_id)
 	if e != nil {
 		log.Fatal(e)
 	}
 
-	full_in := io.MultiReader(buffered, in)
-	e = dump_ts(keep_pids, full_in, out)
+	full_in := io.MultiReader(buffered, pass_in)
+	stats, e := dump_ts(keep_pids, full_in, out)
+	if stats.ResyncCount > 0 {
+		log.Printf("stream quality: resynchronized %d time(s), dropped %d byte(s)",
+			stats.ResyncCount, stats.DroppedBytes)
+	}
 	if e != nil {
 		log.Fatal(e)
 	}